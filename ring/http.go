@@ -0,0 +1,185 @@
+package ring
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// CASClient is implemented by CoordinationStateClient backends that
+// support compare-and-swap. It's kept separate from CoordinationStateClient
+// itself (rather than widening that interface) so that a backend which only
+// supports eventual-consistency merging can satisfy the base interface
+// without a CAS method. The memberlist client does have one, which always
+// returns an error, so forgetting an ingester fails with that error there.
+type CASClient interface {
+	// CAS atomically mutates the value stored under key: f is called with
+	// the current value (decoded via factory, nil if none exists yet) and
+	// returns the value to write back, whether to retry on conflict, and
+	// an error to abort on.
+	CAS(key string, factory InstanceFactory, f func(in interface{}) (out interface{}, retry bool, err error)) error
+}
+
+// errCASNotSupported is returned when a ring operation needs CAS (e.g.
+// forgetting an ingester) but the configured CoordinationStateClient
+// doesn't implement CASClient.
+var errCASNotSupported = errors.New("ring: coordination backend does not support CAS")
+
+var ringPageTemplate = template.Must(template.New("ring").Parse(`
+<!DOCTYPE html>
+<html>
+	<head><title>Ring Status</title></head>
+	<body>
+		<h1>Ring Status</h1>
+		<table border="1">
+			<thead>
+				<tr>
+					<th>Ingester</th>
+					<th>State</th>
+					<th>Address</th>
+					<th>Last Heartbeat</th>
+					<th>Tokens</th>
+					<th>Ownership</th>
+					<th></th>
+				</tr>
+			</thead>
+			<tbody>
+			{{ range .Ingesters }}
+				<tr>
+					<td>{{ .ID }}</td>
+					<td>{{ .State }}</td>
+					<td>{{ .Address }}</td>
+					<td>{{ .HeartbeatAge }}{{ if .Unhealthy }} (unhealthy){{ end }}</td>
+					<td>{{ .NumTokens }}</td>
+					<td>{{ printf "%.2f%%" .OwnershipPercent }}</td>
+					<td>
+						<form method="POST">
+							<input type="hidden" name="forget" value="{{ .ID }}" />
+							<input type="submit" value="Forget" />
+						</form>
+					</td>
+				</tr>
+			{{ end }}
+			</tbody>
+		</table>
+	</body>
+</html>`))
+
+// ringPageIngester is the per-ingester row rendered by ServeHTTP, in both
+// the HTML table and the JSON encoding.
+type ringPageIngester struct {
+	ID               string        `json:"id"`
+	State            string        `json:"state"`
+	Address          string        `json:"address"`
+	HeartbeatAge     time.Duration `json:"heartbeat_age"`
+	Unhealthy        bool          `json:"unhealthy"`
+	NumTokens        int           `json:"num_tokens"`
+	OwnershipPercent float64       `json:"ownership_percent"`
+}
+
+type ringPageData struct {
+	Ingesters []ringPageIngester `json:"ingesters"`
+}
+
+// ServeHTTP renders the current state of the ring for live inspection. A
+// GET returns an HTML table by default, or a JSON document if the request
+// asks for "application/json" or passes "?format=json". A POST with a
+// "forget" form value removes that ingester (and its tokens) from the
+// ring, which requires the configured CoordinationStateClient to support
+// CAS (see CASClient).
+func (r *Ring) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		id := req.FormValue("forget")
+		if id == "" {
+			http.Error(w, "missing forget parameter", http.StatusBadRequest)
+			return
+		}
+		if err := r.forget(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, req.URL.Path, http.StatusFound)
+		return
+	}
+
+	data := r.ringPageData()
+
+	if req.FormValue("format") == "json" || req.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := ringPageTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (r *Ring) ringPageData() ringPageData {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	numTokens := map[string]int{}
+	owned := map[string]uint32{}
+	for i, token := range r.ringDesc.Tokens {
+		numTokens[token.Ingester]++
+
+		var diff uint32
+		if i+1 == len(r.ringDesc.Tokens) {
+			diff = (1<<32 - 1 - token.Token) + r.ringDesc.Tokens[0].Token
+		} else {
+			diff = r.ringDesc.Tokens[i+1].Token - token.Token
+		}
+		owned[token.Ingester] += diff
+	}
+
+	data := ringPageData{Ingesters: make([]ringPageIngester, 0, len(r.ringDesc.Ingesters))}
+	now := time.Now()
+	for id, ing := range r.ringDesc.Ingesters {
+		age := now.Sub(ing.Timestamp)
+		data.Ingesters = append(data.Ingesters, ringPageIngester{
+			ID:               id,
+			State:            ing.State.String(),
+			Address:          ing.Addr,
+			HeartbeatAge:     age,
+			Unhealthy:        age > r.heartbeatTimeout,
+			NumTokens:        numTokens[id],
+			OwnershipPercent: float64(owned[id]) / float64(1<<32-1) * 100,
+		})
+	}
+	sort.Slice(data.Ingesters, func(i, j int) bool { return data.Ingesters[i].ID < data.Ingesters[j].ID })
+
+	return data
+}
+
+// forget removes an ingester and its tokens from the ring via a CAS on the
+// underlying coordination backend.
+func (r *Ring) forget(id string) error {
+	cc, ok := r.client.(CASClient)
+	if !ok {
+		return errCASNotSupported
+	}
+
+	return cc.CAS(r.key, descFactory, func(in interface{}) (interface{}, bool, error) {
+		desc, ok := in.(*Desc)
+		if !ok || desc == nil {
+			return nil, false, ErrEmptyRing
+		}
+
+		delete(desc.Ingesters, id)
+
+		tokens := desc.Tokens[:0]
+		for _, t := range desc.Tokens {
+			if t.Ingester != id {
+				tokens = append(tokens, t)
+			}
+		}
+		desc.Tokens = tokens
+
+		return desc, true, nil
+	})
+}
@@ -0,0 +1,103 @@
+package ring
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noCASClient is a CoordinationStateClient that doesn't implement
+// CASClient, to exercise the errCASNotSupported path.
+type noCASClient struct{}
+
+func (noCASClient) WatchKey(key string, factory InstanceFactory, done <-chan struct{}, f func(interface{}) bool) {
+}
+
+func newTestRing() *Ring {
+	now := time.Now()
+	return &Ring{
+		client:           noCASClient{},
+		heartbeatTimeout: time.Minute,
+		ringDesc: Desc{
+			Ingesters: map[string]IngesterDesc{
+				"a": {Addr: "1.1.1.1", Timestamp: now, State: Active},
+				"b": {Addr: "2.2.2.2", Timestamp: now.Add(-time.Hour), State: Active},
+			},
+			Tokens: []TokenDesc{
+				{Token: 0, Ingester: "a", State: Active},
+				{Token: 1000, Ingester: "b", State: Active},
+			},
+		},
+	}
+}
+
+func TestRingServeHTTP_JSON(t *testing.T) {
+	r := newTestRing()
+
+	req := httptest.NewRequest("GET", "/ring?format=json", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON content type, got %q", ct)
+	}
+
+	var data ringPageData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(data.Ingesters) != 2 {
+		t.Fatalf("expected 2 ingesters, got %d", len(data.Ingesters))
+	}
+	for _, ing := range data.Ingesters {
+		if ing.ID == "b" && !ing.Unhealthy {
+			t.Fatal("expected ingester b, which hasn't heartbeated in an hour, to be marked unhealthy")
+		}
+	}
+}
+
+func TestRingServeHTTP_HTML(t *testing.T) {
+	r := newTestRing()
+
+	req := httptest.NewRequest("GET", "/ring", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "Ring Status") {
+		t.Fatalf("expected HTML table, got %s", w.Body.String())
+	}
+}
+
+func TestRingServeHTTP_ForgetWithoutCASClient(t *testing.T) {
+	r := newTestRing()
+
+	req := httptest.NewRequest("POST", "/ring", strings.NewReader("forget=a"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 500 {
+		t.Fatalf("expected 500 when the backend doesn't support CAS, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRingServeHTTP_ForgetMissingParameter(t *testing.T) {
+	r := newTestRing()
+
+	req := httptest.NewRequest("POST", "/ring", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != 400 {
+		t.Fatalf("expected 400 for a missing forget parameter, got %d", w.Code)
+	}
+}
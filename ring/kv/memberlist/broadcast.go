@@ -0,0 +1,20 @@
+package memberlist
+
+import "github.com/hashicorp/memberlist"
+
+// simpleBroadcast implements memberlist.Broadcast for a single, already
+// terminal, message: merges converge regardless of which broadcast wins,
+// so there's nothing to invalidate and nothing to do once it's sent.
+type simpleBroadcast []byte
+
+func (b simpleBroadcast) Invalidates(other memberlist.Broadcast) bool {
+	return false
+}
+
+func (b simpleBroadcast) Message() []byte {
+	return b
+}
+
+func (b simpleBroadcast) Finished() {}
+
+var _ memberlist.Broadcast = simpleBroadcast(nil)
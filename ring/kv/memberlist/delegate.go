@@ -0,0 +1,83 @@
+package memberlist
+
+import (
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/common/log"
+
+	"github.com/awesomegolang/cortex/ring"
+)
+
+// delegate wires memberlist's broadcast/state-sync callbacks into Client.
+type delegate struct {
+	c *Client
+}
+
+// NodeMeta implements memberlist.Delegate. We don't attach any metadata to
+// nodes themselves; all state travels as keyed values.
+func (d *delegate) NodeMeta(limit int) []byte {
+	return nil
+}
+
+// NotifyMsg implements memberlist.Delegate: handles an incoming broadcast
+// from another node, merging it into local state.
+func (d *delegate) NotifyMsg(msg []byte) {
+	key, data, err := decodeMessage(msg)
+	if err != nil {
+		log.Warnf("memberlist: dropping malformed message: %v", err)
+		return
+	}
+	desc := ring.NewDesc()
+	if err := desc.Unmarshal(data); err != nil {
+		log.Warnf("memberlist: dropping message for %s, can't unmarshal: %v", key, err)
+		return
+	}
+	// Already a delta that arrived via gossip: merge, but don't
+	// rebroadcast the delta again (the TransmitLimitedQueue already
+	// handles retransmission).
+	d.c.mergeLocal(key, desc, false)
+}
+
+// GetBroadcasts implements memberlist.Delegate, returning queued deltas to
+// piggy-back on the next round of gossip.
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
+	return d.c.broadcasts.GetBroadcasts(overhead, limit)
+}
+
+// LocalState implements memberlist.Delegate: the full local state, sent to
+// a peer during a push/pull state sync (e.g. right after it joins).
+func (d *delegate) LocalState(join bool) []byte {
+	d.c.mtx.Lock()
+	defer d.c.mtx.Unlock()
+
+	var out []byte
+	for key, desc := range d.c.values {
+		data, err := desc.Marshal()
+		if err != nil {
+			continue
+		}
+		out = append(out, encodeMessage(key, data)...)
+	}
+	return out
+}
+
+// MergeRemoteState implements memberlist.Delegate: applies the full state
+// of a peer received during push/pull sync.
+func (d *delegate) MergeRemoteState(buf []byte, join bool) {
+	for len(buf) > 0 {
+		key, data, rest, err := decodeOneMessage(buf)
+		if err != nil {
+			log.Warnf("memberlist: dropping malformed remote state: %v", err)
+			return
+		}
+		buf = rest
+
+		desc := ring.NewDesc()
+		if err := desc.Unmarshal(data); err != nil {
+			log.Warnf("memberlist: dropping remote state for %s, can't unmarshal: %v", key, err)
+			continue
+		}
+		d.c.mergeLocal(key, desc, false)
+	}
+}
+
+var _ memberlist.Delegate = (*delegate)(nil)
@@ -0,0 +1,247 @@
+// Package memberlist provides a ring.CoordinationStateClient backed by
+// HashiCorp memberlist, so that a Cortex ring can converge via gossip
+// instead of a centralized KV store such as Consul.
+package memberlist
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+
+	"github.com/awesomegolang/cortex/ring"
+)
+
+// errCASUnsupported is returned by Client.CAS: the memberlist backend
+// converges via Merge, not compare-and-swap.
+var errCASUnsupported = errors.New("memberlist: CAS is not supported, values must merge instead")
+
+// maxGossipPacketSize is the soft cap on a single encoded delta: a larger
+// one is logged by broadcastDelta but still queued, not split up.
+const maxGossipPacketSize = 1400
+
+// Config configures the memberlist-backed CoordinationStateClient.
+type Config struct {
+	// NodeName is this process's identity in the memberlist cluster. If
+	// empty, memberlist generates a random one.
+	NodeName string
+
+	// BindAddr/BindPort is where this node listens for gossip traffic.
+	BindAddr string
+	BindPort int
+
+	// JoinMembers are the seed peers used to join the cluster on startup.
+	JoinMembers []string
+
+	// RetransmitMult controls how many times a broadcast is retransmitted,
+	// as a multiple of log(N) cluster members.
+	RetransmitMult int
+}
+
+// Client is a ring.CoordinationStateClient that replicates values between
+// nodes using memberlist gossip rather than a centralized store. Writers
+// don't coordinate via CAS; instead every value must implement merging
+// that converges regardless of delivery order (see ring.Desc.Merge).
+type Client struct {
+	cfg Config
+
+	memberlist *memberlist.Memberlist
+	broadcasts *memberlist.TransmitLimitedQueue
+
+	mtx      sync.Mutex
+	values   map[string]*ring.Desc
+	watchers map[string][]chan struct{}
+
+	numPeersDesc     *prometheus.Desc
+	messagesSentDesc *prometheus.Desc
+	messagesSent     uint64
+}
+
+// NewClient creates a Client and joins the memberlist cluster described by
+// cfg. It tries to join the seed peers before returning; a failed join is
+// logged rather than returned, so a cluster can be bootstrapped
+// node-by-node.
+func NewClient(cfg Config) (*Client, error) {
+	c := &Client{
+		cfg:      cfg,
+		values:   map[string]*ring.Desc{},
+		watchers: map[string][]chan struct{}{},
+		numPeersDesc: prometheus.NewDesc(
+			"cortex_ring_memberlist_cluster_members",
+			"Number of nodes visible in the memberlist cluster",
+			nil, nil,
+		),
+		messagesSentDesc: prometheus.NewDesc(
+			"cortex_ring_memberlist_messages_sent_total",
+			"Total number of gossip broadcast messages sent",
+			nil, nil,
+		),
+	}
+
+	retransmit := cfg.RetransmitMult
+	if retransmit <= 0 {
+		retransmit = 3
+	}
+	c.broadcasts = &memberlist.TransmitLimitedQueue{
+		NumNodes:       c.numMembers,
+		RetransmitMult: retransmit,
+	}
+
+	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.Name = cfg.NodeName
+	if cfg.BindAddr != "" {
+		mlConfig.BindAddr = cfg.BindAddr
+	}
+	if cfg.BindPort != 0 {
+		mlConfig.BindPort = cfg.BindPort
+		mlConfig.AdvertisePort = cfg.BindPort
+	}
+	mlConfig.Delegate = &delegate{c: c}
+
+	ml, err := memberlist.Create(mlConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.memberlist = ml
+
+	if len(cfg.JoinMembers) > 0 {
+		if _, err := ml.Join(cfg.JoinMembers); err != nil {
+			log.Warnf("memberlist: failed to join %v: %v", cfg.JoinMembers, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Client) numMembers() int {
+	if c.memberlist == nil {
+		return 1
+	}
+	return c.memberlist.NumMembers()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.numPeersDesc
+	ch <- c.messagesSentDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.numPeersDesc, prometheus.GaugeValue, float64(c.numMembers()))
+	c.mtx.Lock()
+	sent := c.messagesSent
+	c.mtx.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.messagesSentDesc, prometheus.CounterValue, float64(sent))
+}
+
+// WatchKey implements ring.CoordinationStateClient. f is invoked once
+// immediately with the current value (nil if we haven't seen one yet),
+// and again every time a local merge produces a value that differs from
+// what f last saw, until done is closed or f returns false.
+func (c *Client) WatchKey(key string, factory ring.InstanceFactory, done <-chan struct{}, f func(interface{}) bool) {
+	notify := make(chan struct{}, 1)
+	c.mtx.Lock()
+	c.watchers[key] = append(c.watchers[key], notify)
+	current := c.values[key]
+	c.mtx.Unlock()
+
+	var value interface{}
+	if current != nil {
+		value = current
+	}
+	if !f(value) {
+		return
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-notify:
+			c.mtx.Lock()
+			v := c.values[key]
+			c.mtx.Unlock()
+			if !f(v) {
+				return
+			}
+		}
+	}
+}
+
+// mergeLocal applies an incoming (key, desc) pair to local state and, if it
+// changed the merged value, notifies watchers and, if rebroadcast is set,
+// broadcasts the delta.
+func (c *Client) mergeLocal(key string, in *ring.Desc, rebroadcast bool) {
+	c.mtx.Lock()
+	existing := c.values[key]
+	var merged *ring.Desc
+	if existing == nil {
+		merged = in
+	} else {
+		merged = existing.Merge(in)
+	}
+	changed := existing == nil || !sameDesc(existing, merged)
+	c.values[key] = merged
+	watchers := c.watchers[key]
+	c.mtx.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, w := range watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+	if rebroadcast {
+		c.broadcastDelta(key, in)
+	}
+}
+
+// broadcastDelta queues just the incoming delta (not the full merged
+// state) for gossip to the rest of the cluster. memberlist's own UDP
+// transport takes care of packing multiple queued broadcasts into
+// compound messages up to the size memberlist negotiates with peers; we
+// only guard against a single delta being implausibly large, since that
+// would starve every other broadcast sharing the packet budget.
+func (c *Client) broadcastDelta(key string, delta *ring.Desc) {
+	data, err := delta.Marshal()
+	if err != nil {
+		log.Warnf("memberlist: failed to marshal delta for %s: %v", key, err)
+		return
+	}
+	msg := encodeMessage(key, data)
+	if len(msg) > maxGossipPacketSize {
+		log.Warnf("memberlist: delta for %s is %d bytes, exceeds the %d byte soft cap; broadcasting anyway", key, len(msg), maxGossipPacketSize)
+	}
+	c.broadcasts.QueueBroadcast(simpleBroadcast(msg))
+	c.mtx.Lock()
+	c.messagesSent++
+	c.mtx.Unlock()
+}
+
+// Set merges desc into the local value stored under key and broadcasts
+// the resulting delta to the rest of the cluster. This is how an
+// ingester publishes its own heartbeat and tokens: the memberlist backend
+// has no CAS, so writers call Set instead of looping on CAS.
+func (c *Client) Set(key string, desc *ring.Desc) {
+	c.mergeLocal(key, desc, true)
+}
+
+// CAS is not supported by the memberlist backend: writes converge through
+// Merge instead of compare-and-swap, so callers should use Set rather
+// than a CAS loop. It's provided so Client satisfies interfaces that
+// expect one, returning an error if ever called.
+func (c *Client) CAS(key string, factory ring.InstanceFactory, f func(in interface{}) (out interface{}, retry bool, err error)) error {
+	return errCASUnsupported
+}
+
+func sameDesc(a, b *ring.Desc) bool {
+	ab, _ := a.Marshal()
+	bb, _ := b.Marshal()
+	return string(ab) == string(bb)
+}
@@ -0,0 +1,134 @@
+package memberlist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+
+	"github.com/awesomegolang/cortex/ring"
+)
+
+func descWith(id string, ts time.Time, tokens ...uint32) *ring.Desc {
+	d := ring.NewDesc()
+	d.Ingesters[id] = ring.IngesterDesc{Addr: id, Timestamp: ts, State: ring.Active}
+	for _, t := range tokens {
+		d.Tokens = append(d.Tokens, ring.TokenDesc{Token: t, Ingester: id, State: ring.Active})
+	}
+	return d
+}
+
+func newTestClient() *Client {
+	return &Client{values: map[string]*ring.Desc{}, watchers: map[string][]chan struct{}{}}
+}
+
+func TestMergeLocal_ConcurrentJoinsConverge(t *testing.T) {
+	now := time.Now()
+	c1 := newTestClient()
+	c2 := newTestClient()
+
+	a := descWith("a", now, 1, 2)
+	b := descWith("b", now, 3, 4)
+
+	// Simulate two nodes seeing the same two joins in opposite order.
+	c1.mergeLocal("ring", a, false)
+	c1.mergeLocal("ring", b, false)
+
+	c2.mergeLocal("ring", b, false)
+	c2.mergeLocal("ring", a, false)
+
+	if !sameDesc(c1.values["ring"], c2.values["ring"]) {
+		t.Fatalf("merge order changed the converged value:\n%v\n%v", c1.values["ring"], c2.values["ring"])
+	}
+	if len(c1.values["ring"].Ingesters) != 2 || len(c1.values["ring"].Tokens) != 4 {
+		t.Fatalf("expected both ingesters and all 4 tokens, got %+v", c1.values["ring"])
+	}
+}
+
+func TestMergeLocal_TieBreakIsOrderIndependent(t *testing.T) {
+	now := time.Now()
+	c1 := newTestClient()
+	c2 := newTestClient()
+
+	// Two ingesters racing to claim the same token at the exact same
+	// timestamp - a routine occurrence under low clock resolution.
+	a := descWith("a", now, 5)
+	b := descWith("b", now, 5)
+
+	c1.mergeLocal("ring", a, false)
+	c1.mergeLocal("ring", b, false)
+
+	c2.mergeLocal("ring", b, false)
+	c2.mergeLocal("ring", a, false)
+
+	if !sameDesc(c1.values["ring"], c2.values["ring"]) {
+		t.Fatalf("tie-break depended on merge order:\n%v\n%v", c1.values["ring"], c2.values["ring"])
+	}
+}
+
+func TestMergeLocal_LeavingWinsOnNewerTimestamp(t *testing.T) {
+	now := time.Now()
+	c := newTestClient()
+
+	joined := descWith("a", now, 1)
+	c.mergeLocal("ring", joined, false)
+
+	left := ring.NewDesc()
+	left.Ingesters["a"] = ring.IngesterDesc{Addr: "a", Timestamp: now.Add(time.Second), State: ring.Leaving}
+	c.mergeLocal("ring", left, false)
+
+	got := c.values["ring"].Ingesters["a"]
+	if got.State != ring.Leaving {
+		t.Fatalf("expected newer Leaving write to win, got state %v", got.State)
+	}
+}
+
+func TestMergeLocal_NotifiesWatchersOnChange(t *testing.T) {
+	c := newTestClient()
+	notify := make(chan struct{}, 1)
+	c.watchers["ring"] = []chan struct{}{notify}
+
+	c.mergeLocal("ring", descWith("a", time.Now(), 1), false)
+
+	select {
+	case <-notify:
+	default:
+		t.Fatal("expected watcher to be notified of the new value")
+	}
+}
+
+func TestClient_SetPublishesLocalValue(t *testing.T) {
+	c := newTestClient()
+	c.broadcasts = &memberlist.TransmitLimitedQueue{NumNodes: func() int { return 1 }}
+
+	desc := descWith("a", time.Now(), 1)
+	c.Set("ring", desc)
+
+	if _, ok := c.values["ring"]; !ok {
+		t.Fatal("expected Set to merge the value into local state")
+	}
+	if c.messagesSent != 1 {
+		t.Fatalf("expected Set to broadcast the delta, sent=%d", c.messagesSent)
+	}
+	if c.broadcasts.NumQueued() != 1 {
+		t.Fatalf("expected the delta to be queued for gossip, queued=%d", c.broadcasts.NumQueued())
+	}
+}
+
+func TestDesc_MarshalUnmarshalRoundTrip(t *testing.T) {
+	d := descWith("a", time.Now().Round(0), 1, 2)
+	d.Ingesters["b"] = ring.IngesterDesc{Addr: "b", Timestamp: time.Now().Round(0), State: ring.Leaving, Zone: "zone-a"}
+
+	data, err := d.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	got := ring.NewDesc()
+	if err := got.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !sameDesc(d, got) {
+		t.Fatalf("round-tripped Desc differs from original:\n%+v\n%+v", d, got)
+	}
+}
@@ -0,0 +1,57 @@
+package memberlist
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// encodeMessage frames a (key, data) pair as:
+//   [2 bytes key length][key][4 bytes data length][data]
+// so MergeRemoteState can concatenate several of these and split them
+// back apart again.
+func encodeMessage(key string, data []byte) []byte {
+	out := make([]byte, 2+len(key)+4+len(data))
+	binary.BigEndian.PutUint16(out[0:2], uint16(len(key)))
+	copy(out[2:], key)
+	off := 2 + len(key)
+	binary.BigEndian.PutUint32(out[off:off+4], uint32(len(data)))
+	copy(out[off+4:], data)
+	return out
+}
+
+// decodeMessage decodes a single message previously produced by
+// encodeMessage, with no trailing bytes expected.
+func decodeMessage(msg []byte) (key string, data []byte, err error) {
+	key, data, rest, err := decodeOneMessage(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(rest) != 0 {
+		return "", nil, fmt.Errorf("memberlist: %d unexpected trailing bytes", len(rest))
+	}
+	return key, data, nil
+}
+
+// decodeOneMessage decodes the first message out of buf and returns the
+// remaining, possibly empty, bytes.
+func decodeOneMessage(buf []byte) (key string, data []byte, rest []byte, err error) {
+	if len(buf) < 2 {
+		return "", nil, nil, fmt.Errorf("memberlist: message too short for key length")
+	}
+	keyLen := int(binary.BigEndian.Uint16(buf[0:2]))
+	buf = buf[2:]
+	if len(buf) < keyLen+4 {
+		return "", nil, nil, fmt.Errorf("memberlist: message too short for key+data length")
+	}
+	key = string(buf[:keyLen])
+	buf = buf[keyLen:]
+
+	dataLen := int(binary.BigEndian.Uint32(buf[0:4]))
+	buf = buf[4:]
+	if len(buf) < dataLen {
+		return "", nil, nil, fmt.Errorf("memberlist: message too short for data")
+	}
+	data = buf[:dataLen]
+	rest = buf[dataLen:]
+	return key, data, rest, nil
+}
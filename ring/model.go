@@ -0,0 +1,185 @@
+package ring
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// consulKey is the default key under which the ring is stored.
+const consulKey = "ring"
+
+// IngesterState describes the state of an ingester in the ring.
+type IngesterState int
+
+// Values for IngesterState.
+const (
+	Active IngesterState = iota
+	Leaving
+	Joining
+	Pending
+)
+
+// String implements fmt.Stringer, returning the conventional uppercase
+// name used in logs and the ring status page.
+func (s IngesterState) String() string {
+	switch s {
+	case Active:
+		return "ACTIVE"
+	case Leaving:
+		return "LEAVING"
+	case Joining:
+		return "JOINING"
+	case Pending:
+		return "PENDING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// IngesterDesc describes an ingester and the tokens it owns.
+type IngesterDesc struct {
+	Addr      string
+	Timestamp time.Time
+	State     IngesterState
+	// Zone is the availability zone the ingester runs in, used for
+	// zone-aware replica placement. Empty when zone awareness is unused.
+	Zone string
+}
+
+// TokenDesc describes a single token on the ring, and the ingester that owns it.
+type TokenDesc struct {
+	Token    uint32
+	Ingester string
+	State    IngesterState
+}
+
+// Desc is the top-level type stored in the coordination KV store. It
+// describes the full state of the ring: every known ingester and every
+// token on the circle.
+type Desc struct {
+	Ingesters map[string]IngesterDesc
+	Tokens    []TokenDesc
+}
+
+// InstanceFactory creates a new, empty value of the type stored under a
+// given key. CoordinationStateClient implementations use it to decode
+// values they don't otherwise know the shape of.
+type InstanceFactory func() interface{}
+
+// descFactory makes a new empty Desc, for use as an InstanceFactory.
+func descFactory() interface{} {
+	return NewDesc()
+}
+
+// NewDesc makes a new empty Desc.
+func NewDesc() *Desc {
+	return &Desc{
+		Ingesters: map[string]IngesterDesc{},
+	}
+}
+
+// Merge returns a new Desc that combines d and other without requiring
+// either side to have seen the other's writes first: it's safe to call
+// concurrently from multiple writers and always converges to the same
+// result regardless of merge order (commutative, associative, idempotent).
+//
+// Ingesters are merged by keeping, per ID, whichever side has the newer
+// Timestamp. Tokens are merged by union, deduplicated by token value; if
+// both sides claim the same token for different ingesters, the token is
+// awarded to the ingester whose merged entry wins ingesterWins against the
+// other's. If both sides list the token for the same ingester with
+// different states, the state matching that ingester's merged entry is
+// kept, else the higher state. Ties
+// (equal Timestamps, which are routine under low clock resolution) are broken
+// by ingesterWins rather than by whichever side happens to be walked
+// first, so the result really is independent of merge order.
+func (d *Desc) Merge(other *Desc) *Desc {
+	merged := NewDesc()
+
+	for id, ing := range d.Ingesters {
+		merged.Ingesters[id] = ing
+	}
+	for id, ing := range other.Ingesters {
+		existing, ok := merged.Ingesters[id]
+		if !ok || ingesterWins(ing, existing) {
+			merged.Ingesters[id] = ing
+		}
+	}
+
+	byToken := map[uint32]TokenDesc{}
+	for _, tok := range d.Tokens {
+		byToken[tok.Token] = tok
+	}
+	for _, tok := range other.Tokens {
+		existing, ok := byToken[tok.Token]
+		if !ok {
+			byToken[tok.Token] = tok
+			continue
+		}
+		if existing.Ingester == tok.Ingester {
+			want := merged.Ingesters[tok.Ingester].State
+			if (tok.State == want) != (existing.State == want) {
+				if tok.State == want {
+					byToken[tok.Token] = tok
+				}
+			} else if tok.State > existing.State {
+				byToken[tok.Token] = tok
+			}
+			continue
+		}
+		// Conflicting claim on the same token: defer to whichever
+		// ingester's write we kept above.
+		if ingesterWins(merged.Ingesters[tok.Ingester], merged.Ingesters[existing.Ingester]) {
+			byToken[tok.Token] = tok
+		}
+	}
+
+	merged.Tokens = make([]TokenDesc, 0, len(byToken))
+	for _, tok := range byToken {
+		merged.Tokens = append(merged.Tokens, tok)
+	}
+	sort.Sort(tokenDescsByToken(merged.Tokens))
+
+	return merged
+}
+
+// ingesterWins reports whether a should be preferred over b when they
+// conflict during a merge: whichever has the newer Timestamp, with ties
+// broken by comparing a textual encoding of the two values. Because that
+// comparison is over the values themselves rather than over which side
+// of the merge produced them, calling ingesterWins(a, b) and
+// ingesterWins(b, a) always disagree (for a != b), so the merge result
+// doesn't depend on merge order.
+func ingesterWins(a, b IngesterDesc) bool {
+	if a.Timestamp.After(b.Timestamp) {
+		return true
+	}
+	if b.Timestamp.After(a.Timestamp) {
+		return false
+	}
+	return fmt.Sprintf("%+v", a) > fmt.Sprintf("%+v", b)
+}
+
+// tokenDescsByToken sorts TokenDescs into ring order, ascending by Token.
+type tokenDescsByToken []TokenDesc
+
+func (t tokenDescsByToken) Len() int           { return len(t) }
+func (t tokenDescsByToken) Less(i, j int) bool { return t[i].Token < t[j].Token }
+func (t tokenDescsByToken) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// Marshal encodes the Desc for storage in the coordination backend, using
+// the protobuf wire format (see proto.go) rather than a more verbose
+// encoding like JSON - this matters for the memberlist backend, which
+// gossips these bytes directly and caps how large a single message can be.
+func (d *Desc) Marshal() ([]byte, error) {
+	return marshalDesc(d), nil
+}
+
+// Unmarshal decodes a Desc previously written by Marshal.
+func (d *Desc) Unmarshal(data []byte) error {
+	if d.Ingesters == nil {
+		d.Ingesters = map[string]IngesterDesc{}
+	}
+	return unmarshalDesc(data, d)
+}
@@ -0,0 +1,286 @@
+package ring
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// This file hand-encodes Desc to the protobuf wire format (varints and
+// length-delimited fields per https://protobuf.dev/programming-guides/encoding/)
+// rather than going through generated code, since there's no .proto/protoc
+// step in this tree. The wire schema it implements is:
+//
+//	message Desc {
+//	  message IngesterEntry { string key = 1; IngesterDesc value = 2; }
+//	  repeated IngesterEntry ingesters = 1;
+//	  repeated TokenDesc tokens = 2;
+//	}
+//	message IngesterDesc {
+//	  string addr = 1;
+//	  int64 timestamp_unix_nano = 2;
+//	  int32 state = 3;
+//	  string zone = 4;
+//	}
+//	message TokenDesc {
+//	  uint32 token = 1;
+//	  string ingester = 2;
+//	  int32 state = 3;
+//	}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendTag(buf []byte, fieldNum, wireType int) []byte {
+	return appendUvarint(buf, uint64(fieldNum)<<3|uint64(wireType))
+}
+
+func appendVarintField(buf []byte, fieldNum int, v uint64) []byte {
+	buf = appendTag(buf, fieldNum, wireVarint)
+	return appendUvarint(buf, v)
+}
+
+func appendBytesField(buf []byte, fieldNum int, b []byte) []byte {
+	buf = appendTag(buf, fieldNum, wireBytes)
+	buf = appendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendStringField(buf []byte, fieldNum int, s string) []byte {
+	return appendBytesField(buf, fieldNum, []byte(s))
+}
+
+// decodeTag reads one tag off the front of buf, returning the field number,
+// wire type and the number of bytes consumed. The field's value is left in
+// buf, to be read with decodeVarintField or decodeBytesField.
+func decodeTag(buf []byte) (fieldNum, wireType int, n int, err error) {
+	v, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, 0, 0, fmt.Errorf("ring: malformed protobuf tag")
+	}
+	return int(v >> 3), int(v & 0x7), n, nil
+}
+
+func decodeVarintField(buf []byte) (v uint64, n int, err error) {
+	v, n = binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("ring: malformed protobuf varint")
+	}
+	return v, n, nil
+}
+
+func decodeBytesField(buf []byte) (v []byte, n int, err error) {
+	l, ln := binary.Uvarint(buf)
+	if ln <= 0 {
+		return nil, 0, fmt.Errorf("ring: malformed protobuf length")
+	}
+	start := ln
+	end := start + int(l)
+	if end > len(buf) {
+		return nil, 0, fmt.Errorf("ring: protobuf length %d exceeds remaining %d bytes", l, len(buf)-start)
+	}
+	return buf[start:end], end, nil
+}
+
+func marshalTokenDesc(t TokenDesc) []byte {
+	var buf []byte
+	buf = appendVarintField(buf, 1, uint64(t.Token))
+	buf = appendStringField(buf, 2, t.Ingester)
+	buf = appendVarintField(buf, 3, uint64(t.State))
+	return buf
+}
+
+func unmarshalTokenDesc(data []byte) (TokenDesc, error) {
+	var t TokenDesc
+	for len(data) > 0 {
+		fieldNum, wireType, n, err := decodeTag(data)
+		if err != nil {
+			return TokenDesc{}, err
+		}
+		data = data[n:]
+		switch {
+		case fieldNum == 1 && wireType == wireVarint:
+			v, n, err := decodeVarintField(data)
+			if err != nil {
+				return TokenDesc{}, err
+			}
+			t.Token = uint32(v)
+			data = data[n:]
+		case fieldNum == 2 && wireType == wireBytes:
+			v, n, err := decodeBytesField(data)
+			if err != nil {
+				return TokenDesc{}, err
+			}
+			t.Ingester = string(v)
+			data = data[n:]
+		case fieldNum == 3 && wireType == wireVarint:
+			v, n, err := decodeVarintField(data)
+			if err != nil {
+				return TokenDesc{}, err
+			}
+			t.State = IngesterState(v)
+			data = data[n:]
+		default:
+			return TokenDesc{}, fmt.Errorf("ring: unknown TokenDesc field %d", fieldNum)
+		}
+	}
+	return t, nil
+}
+
+func marshalIngesterDesc(d IngesterDesc) []byte {
+	var buf []byte
+	buf = appendStringField(buf, 1, d.Addr)
+	buf = appendVarintField(buf, 2, uint64(d.Timestamp.UnixNano()))
+	buf = appendVarintField(buf, 3, uint64(d.State))
+	buf = appendStringField(buf, 4, d.Zone)
+	return buf
+}
+
+func unmarshalIngesterDesc(data []byte) (IngesterDesc, error) {
+	var d IngesterDesc
+	for len(data) > 0 {
+		fieldNum, wireType, n, err := decodeTag(data)
+		if err != nil {
+			return IngesterDesc{}, err
+		}
+		data = data[n:]
+		switch {
+		case fieldNum == 1 && wireType == wireBytes:
+			v, n, err := decodeBytesField(data)
+			if err != nil {
+				return IngesterDesc{}, err
+			}
+			d.Addr = string(v)
+			data = data[n:]
+		case fieldNum == 2 && wireType == wireVarint:
+			v, n, err := decodeVarintField(data)
+			if err != nil {
+				return IngesterDesc{}, err
+			}
+			d.Timestamp = time.Unix(0, int64(v))
+			data = data[n:]
+		case fieldNum == 3 && wireType == wireVarint:
+			v, n, err := decodeVarintField(data)
+			if err != nil {
+				return IngesterDesc{}, err
+			}
+			d.State = IngesterState(v)
+			data = data[n:]
+		case fieldNum == 4 && wireType == wireBytes:
+			v, n, err := decodeBytesField(data)
+			if err != nil {
+				return IngesterDesc{}, err
+			}
+			d.Zone = string(v)
+			data = data[n:]
+		default:
+			return IngesterDesc{}, fmt.Errorf("ring: unknown IngesterDesc field %d", fieldNum)
+		}
+	}
+	return d, nil
+}
+
+// marshalDesc encodes d deterministically: Ingesters is a Go map, so its
+// iteration order varies from call to call, and an encoding that followed
+// that order would make two logically identical Descs marshal to different
+// bytes. That would break sameDesc (byte-for-byte comparison) and make
+// gossiped deltas churn for no reason, so ingesters are always written in
+// sorted-key order.
+func marshalDesc(d *Desc) []byte {
+	keys := make([]string, 0, len(d.Ingesters))
+	for key := range d.Ingesters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf []byte
+	for _, key := range keys {
+		var entry []byte
+		entry = appendStringField(entry, 1, key)
+		entry = appendBytesField(entry, 2, marshalIngesterDesc(d.Ingesters[key]))
+		buf = appendBytesField(buf, 1, entry)
+	}
+	for _, tok := range d.Tokens {
+		buf = appendBytesField(buf, 2, marshalTokenDesc(tok))
+	}
+	return buf
+}
+
+func unmarshalDesc(data []byte, d *Desc) error {
+	for len(data) > 0 {
+		fieldNum, wireType, n, err := decodeTag(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		if wireType != wireBytes {
+			return fmt.Errorf("ring: unexpected wire type %d for Desc field %d", wireType, fieldNum)
+		}
+		entry, n, err := decodeBytesField(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+
+		switch fieldNum {
+		case 1:
+			key, ing, err := unmarshalIngesterEntry(entry)
+			if err != nil {
+				return err
+			}
+			d.Ingesters[key] = ing
+		case 2:
+			tok, err := unmarshalTokenDesc(entry)
+			if err != nil {
+				return err
+			}
+			d.Tokens = append(d.Tokens, tok)
+		default:
+			return fmt.Errorf("ring: unknown Desc field %d", fieldNum)
+		}
+	}
+	return nil
+}
+
+func unmarshalIngesterEntry(data []byte) (string, IngesterDesc, error) {
+	var key string
+	var ing IngesterDesc
+	for len(data) > 0 {
+		fieldNum, wireType, n, err := decodeTag(data)
+		if err != nil {
+			return "", IngesterDesc{}, err
+		}
+		data = data[n:]
+		switch {
+		case fieldNum == 1 && wireType == wireBytes:
+			v, n, err := decodeBytesField(data)
+			if err != nil {
+				return "", IngesterDesc{}, err
+			}
+			key = string(v)
+			data = data[n:]
+		case fieldNum == 2 && wireType == wireBytes:
+			v, n, err := decodeBytesField(data)
+			if err != nil {
+				return "", IngesterDesc{}, err
+			}
+			ing, err = unmarshalIngesterDesc(v)
+			if err != nil {
+				return "", IngesterDesc{}, err
+			}
+			data = data[n:]
+		default:
+			return "", IngesterDesc{}, fmt.Errorf("ring: unknown IngesterEntry field %d", fieldNum)
+		}
+	}
+	return key, ing, nil
+}
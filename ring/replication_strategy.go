@@ -0,0 +1,108 @@
+package ring
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplicationSet describes the ingesters to use for a single operation,
+// and how many of them are allowed to fail before the operation itself
+// should be considered failed.
+type ReplicationSet struct {
+	Ingesters []IngesterDesc
+	MaxErrors int
+}
+
+// ReplicationStrategy decides, given a set of candidate ingesters for a
+// key, which of them to actually use for an operation and how many are
+// allowed to fail. Making this pluggable lets operators trade consistency
+// for availability (e.g. a read-one strategy) without forking the ring.
+type ReplicationStrategy interface {
+	// Filter narrows candidates (as produced by the ring's placement walk)
+	// down to a ReplicationSet. replicationFactor is the ring's configured
+	// RF, passed through since len(candidates) can exceed it (candidates
+	// includes extra entries to absorb Leaving-state ingesters).
+	Filter(candidates []IngesterDesc, op Operation, replicationFactor int, heartbeatTimeout time.Duration) (ReplicationSet, error)
+}
+
+// DefaultReplicationStrategy is Cortex's original quorum behavior: writes
+// go out to every replica and must succeed on all but one of them; reads
+// tolerate one candidate being unhealthy, since the ring walk already
+// over-selects to compensate for exactly that.
+type DefaultReplicationStrategy struct{}
+
+// Filter implements ReplicationStrategy.
+func (DefaultReplicationStrategy) Filter(candidates []IngesterDesc, op Operation, replicationFactor int, heartbeatTimeout time.Duration) (ReplicationSet, error) {
+	now := time.Now()
+	healthy := make([]IngesterDesc, 0, len(candidates))
+	unhealthy := 0
+	for _, ing := range candidates {
+		if now.Sub(ing.Timestamp) > heartbeatTimeout {
+			unhealthy++
+			continue
+		}
+		healthy = append(healthy, ing)
+	}
+
+	// Both reads and writes tolerate exactly one replica being
+	// unavailable: writes need an RF-1 quorum to succeed, and reads rely
+	// on the ring walk having over-selected candidates to absorb one
+	// unhealthy replica, same as it always did before ReplicationSet
+	// existed.
+	maxErrors := 1
+
+	if len(healthy) < replicationFactor-maxErrors {
+		return ReplicationSet{}, fmt.Errorf("at least %d live replicas required, could only find %d (%d unhealthy)", replicationFactor-maxErrors, len(healthy), unhealthy)
+	}
+	if unhealthy > maxErrors {
+		return ReplicationSet{}, fmt.Errorf("at most %d unhealthy replica tolerated, found %d", maxErrors, unhealthy)
+	}
+
+	// An unhealthy candidate already uses up the tolerated failure.
+	return ReplicationSet{Ingesters: healthy, MaxErrors: maxErrors - unhealthy}, nil
+}
+
+// Do fans f out to every ingester in the set concurrently, and returns the
+// results of the first len(Ingesters)-MaxErrors to succeed, cancelling
+// the context passed to the rest. It returns an error as soon as more
+// than MaxErrors calls have failed, without waiting for stragglers.
+func (r ReplicationSet) Do(ctx context.Context, f func(context.Context, IngesterDesc) (interface{}, error)) ([]interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		val interface{}
+		err error
+	}
+
+	resultsCh := make(chan result, len(r.Ingesters))
+	for _, ing := range r.Ingesters {
+		ing := ing
+		go func() {
+			val, err := f(ctx, ing)
+			resultsCh <- result{val, err}
+		}()
+	}
+
+	var (
+		results []interface{}
+		errs    []error
+	)
+	for range r.Ingesters {
+		res := <-resultsCh
+		if res.err != nil {
+			errs = append(errs, res.err)
+			if len(errs) > r.MaxErrors {
+				return nil, res.err
+			}
+			continue
+		}
+
+		results = append(results, res.val)
+		if len(results) == len(r.Ingesters)-r.MaxErrors {
+			return results, nil
+		}
+	}
+	return results, nil
+}
@@ -36,6 +36,11 @@ func (x uint32s) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
 // ErrEmptyRing is the error returned when trying to get an element when nothing has been added to hash.
 var ErrEmptyRing = errors.New("empty circle")
 
+// ErrTooFewZones is returned by Get/BatchGet when zone awareness is
+// enabled but fewer zones than replicas have any healthy ingester, so the
+// requested spread across zones can't be honored.
+var ErrTooFewZones = errors.New("too few zones with healthy ingesters to satisfy replication factor")
+
 // CoordinationStateClient is an interface to getting changes to the coordination
 // state.  Should allow us to swap out Consul for something else (mesh?) later.
 type CoordinationStateClient interface {
@@ -44,9 +49,13 @@ type CoordinationStateClient interface {
 
 // Ring holds the information about the members of the consistent hash circle.
 type Ring struct {
-	client           CoordinationStateClient
-	quit, done       chan struct{}
-	heartbeatTimeout time.Duration
+	key                  string
+	client               CoordinationStateClient
+	quit, done           chan struct{}
+	heartbeatTimeout     time.Duration
+	replicationFactor    int
+	zoneAwarenessEnabled bool
+	strategy             ReplicationStrategy
 
 	mtx      sync.RWMutex
 	ringDesc Desc
@@ -54,15 +63,36 @@ type Ring struct {
 	ingesterOwnershipDesc *prometheus.Desc
 	numIngestersDesc      *prometheus.Desc
 	numTokensDesc         *prometheus.Desc
+	numZonesDesc          *prometheus.Desc
 }
 
-// New creates a new Ring
-func New(client CoordinationStateClient, heartbeatTimeout time.Duration) *Ring {
+// New creates a new Ring. key is the name the ring is stored under in the
+// coordination backend; callers running multiple rings against the same
+// backend (or migrating between backends) should pass distinct keys.
+//
+// replicationFactor and zoneAwarenessEnabled configure zone-aware replica
+// placement in candidatesFor: when zoneAwarenessEnabled is false, placement
+// behaves exactly as before zones existed.
+//
+// strategy decides, from the replicationFactor candidates, which to
+// actually use and how many are allowed to fail; pass nil to get
+// DefaultReplicationStrategy, Cortex's original quorum behavior.
+func New(client CoordinationStateClient, key string, heartbeatTimeout time.Duration, replicationFactor int, zoneAwarenessEnabled bool, strategy ReplicationStrategy) *Ring {
+	if key == "" {
+		key = consulKey
+	}
+	if strategy == nil {
+		strategy = DefaultReplicationStrategy{}
+	}
 	r := &Ring{
-		client:           client,
-		heartbeatTimeout: heartbeatTimeout,
-		quit:             make(chan struct{}),
-		done:             make(chan struct{}),
+		key:                  key,
+		client:               client,
+		heartbeatTimeout:     heartbeatTimeout,
+		replicationFactor:    replicationFactor,
+		zoneAwarenessEnabled: zoneAwarenessEnabled,
+		strategy:             strategy,
+		quit:                 make(chan struct{}),
+		done:                 make(chan struct{}),
 		ingesterOwnershipDesc: prometheus.NewDesc(
 			"cortex_ring_ingester_ownership_percent",
 			"The percent ownership of the ring by ingester",
@@ -78,6 +108,11 @@ func New(client CoordinationStateClient, heartbeatTimeout time.Duration) *Ring {
 			"Number of tokens in the ring",
 			nil, nil,
 		),
+		numZonesDesc: prometheus.NewDesc(
+			"cortex_ring_zones",
+			"Number of zones with at least one ingester in a given state",
+			[]string{"state"}, nil,
+		),
 	}
 	go r.loop()
 	return r
@@ -91,9 +126,9 @@ func (r *Ring) Stop() {
 
 func (r *Ring) loop() {
 	defer close(r.done)
-	r.client.WatchKey(consulKey, descFactory, r.quit, func(value interface{}) bool {
+	r.client.WatchKey(r.key, descFactory, r.quit, func(value interface{}) bool {
 		if value == nil {
-			log.Infof("Ring doesn't exist in consul yet.")
+			log.Infof("Ring doesn't exist in the KV store yet.")
 			return true
 		}
 
@@ -105,55 +140,138 @@ func (r *Ring) loop() {
 	})
 }
 
-// Get returns n (or more) ingesters which form the replicas for the given key.
-func (r *Ring) Get(key uint32, n int, op Operation) ([]IngesterDesc, error) {
+// Get returns the ReplicationSet to use for op on key: which ingesters to
+// contact, and how many of them are allowed to fail before op itself
+// should be considered failed. Which ingesters and how much failure is
+// tolerated is up to the Ring's ReplicationStrategy.
+func (r *Ring) Get(key uint32, op Operation) (ReplicationSet, error) {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
-	return r.getInternal(key, n, op)
+	return r.getInternal(key, op)
 }
 
-// BatchGet returns n (or more) ingesters which form the replicas for the given key.
-// The order of the result matches the order of the input.
-func (r *Ring) BatchGet(keys []uint32, n int, op Operation) ([][]IngesterDesc, error) {
+// BatchGet returns a ReplicationSet for each key. The order of the result
+// matches the order of the input.
+func (r *Ring) BatchGet(keys []uint32, op Operation) ([]ReplicationSet, error) {
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
 
-	result := make([][]IngesterDesc, len(keys), len(keys))
+	result := make([]ReplicationSet, len(keys))
 	for i, key := range keys {
-		ingesters, err := r.getInternal(key, n, op)
+		rs, err := r.getInternal(key, op)
 		if err != nil {
 			return nil, err
 		}
-		result[i] = ingesters
+		result[i] = rs
 	}
 	return result, nil
 }
 
-func (r *Ring) getInternal(key uint32, n int, op Operation) ([]IngesterDesc, error) {
-	r.mtx.RLock()
-	defer r.mtx.RUnlock()
+func (r *Ring) getInternal(key uint32, op Operation) (ReplicationSet, error) {
+	candidates, err := r.candidatesFor(key, r.replicationFactor, op)
+	if err != nil {
+		return ReplicationSet{}, err
+	}
+	return r.strategy.Filter(candidates, op, r.replicationFactor, r.heartbeatTimeout)
+}
+
+// candidatesFor walks the ring from key, collecting n (or more, to absorb
+// Leaving-state ingesters) distinct candidate ingesters, spread across
+// zones if zone awareness is enabled. It's the raw ring placement; whether
+// these candidates are actually healthy enough to use is decided
+// downstream by a ReplicationStrategy.
+func (r *Ring) candidatesFor(key uint32, n int, op Operation) ([]IngesterDesc, error) {
 	if len(r.ringDesc.Tokens) == 0 {
 		return nil, ErrEmptyRing
 	}
 
-	ingesters := make([]IngesterDesc, 0, n)
+	var zones map[string]struct{}
+	if r.zoneAwarenessEnabled {
+		zones = r.healthyZones(nil)
+		if len(zones) < n {
+			return nil, ErrTooFewZones
+		}
+	}
+
+	return walkRingForCandidates(r.ringDesc.Tokens, r.ringDesc.Ingesters, key, n, op, zones), nil
+}
+
+// healthyZones returns the set of zones with at least one healthy
+// ingester. If ids is non-nil, only ingesters whose ID is in ids are
+// considered (used by Subring to scope this to shard membership);
+// otherwise every ingester in the ring is considered.
+//
+// Both the upfront "enough zones?" check and the zone-spread walk itself
+// need this same healthy-only set: a zone whose only member is unhealthy
+// must not count as already represented, or the walk could burn a
+// replica slot on a dead ingester while a healthy replica in another zone
+// goes unused.
+func (r *Ring) healthyZones(ids map[string]struct{}) map[string]struct{} {
+	zones := map[string]struct{}{}
+	now := time.Now()
+	for id, ing := range r.ringDesc.Ingesters {
+		if ids != nil {
+			if _, ok := ids[id]; !ok {
+				continue
+			}
+		}
+		if now.Sub(ing.Timestamp) > r.heartbeatTimeout {
+			continue
+		}
+		zones[ing.Zone] = struct{}{}
+	}
+	return zones
+}
+
+// walkRingForCandidates walks tokens starting from key, collecting n (or
+// more, to absorb Leaving-state ingesters) distinct candidate ingesters.
+// If zones is non-nil, it's the required set of zones to draw from (e.g.
+// zones with at least one healthy ingester): tokens belonging to any other
+// zone are skipped outright, and placement spreads across the required
+// zones - one replica per zone - until every one of them has contributed,
+// before falling back to plain per-host uniqueness. Pass nil to skip zone
+// awareness entirely. Shared by Ring.candidatesFor and Subring.candidatesFor.
+func walkRingForCandidates(tokens []TokenDesc, ingesters map[string]IngesterDesc, key uint32, n int, op Operation, zones map[string]struct{}) []IngesterDesc {
+	result := make([]IngesterDesc, 0, n)
 	distinctHosts := map[string]struct{}{}
-	start := r.search(key)
+	distinctZones := map[string]struct{}{}
+	start := searchTokens(tokens, key)
 	iterations := 0
-	for i := start; len(distinctHosts) < n && iterations < len(r.ringDesc.Tokens); i++ {
+	for i := start; len(distinctHosts) < n && iterations < len(tokens); i++ {
 		iterations++
 		// Wrap i around in the ring.
-		i %= len(r.ringDesc.Tokens)
+		i %= len(tokens)
 
 		// We want n *distinct* ingesters.
-		token := r.ringDesc.Tokens[i]
+		token := tokens[i]
 		if _, ok := distinctHosts[token.Ingester]; ok {
 			continue
 		}
+		ing := ingesters[token.Ingester]
+
+		if zones != nil {
+			// A zone outside the required set (e.g. one with no healthy
+			// ingester) never contributes a replica, regardless of how
+			// many distinct zones we've collected so far.
+			if _, required := zones[ing.Zone]; !required {
+				continue
+			}
+			// Until every required zone has contributed at least one
+			// replica, skip tokens belonging to a zone we've already
+			// used so replicas spread out; once every zone is
+			// represented, fall back to plain per-host uniqueness.
+			if len(distinctZones) < len(zones) {
+				if _, used := distinctZones[ing.Zone]; used {
+					continue
+				}
+			}
+		}
 		distinctHosts[token.Ingester] = struct{}{}
 
 		// If we encounter a Leaving token, for reads we should bump n,
-		// for writes we bump n and skip the token.
+		// for writes we bump n and skip the token. This bump-and-skip
+		// applies per host, not per zone, so a skipped write doesn't
+		// count as having used that zone's slot.
 		if token.State == Leaving {
 			n++
 			if op == Write {
@@ -161,10 +279,10 @@ func (r *Ring) getInternal(key uint32, n int, op Operation) ([]IngesterDesc, err
 			}
 		}
 
-		ing := r.ringDesc.Ingesters[token.Ingester]
-		ingesters = append(ingesters, ing)
+		distinctZones[ing.Zone] = struct{}{}
+		result = append(result, ing)
 	}
-	return ingesters, nil
+	return result
 }
 
 // GetAll returns all available ingesters in the circle.
@@ -182,11 +300,13 @@ func (r *Ring) GetAll() []IngesterDesc {
 	return ingesters
 }
 
-func (r *Ring) search(key uint32) int {
-	i := sort.Search(len(r.ringDesc.Tokens), func(x int) bool {
-		return r.ringDesc.Tokens[x].Token > key
+// searchTokens finds the index of the first token greater than key,
+// wrapping around to 0 if key is greater than every token.
+func searchTokens(tokens []TokenDesc, key uint32) int {
+	i := sort.Search(len(tokens), func(x int) bool {
+		return tokens[x].Token > key
 	})
-	if i >= len(r.ringDesc.Tokens) {
+	if i >= len(tokens) {
 		i = 0
 	}
 	return i
@@ -197,6 +317,7 @@ func (r *Ring) Describe(ch chan<- *prometheus.Desc) {
 	ch <- r.ingesterOwnershipDesc
 	ch <- r.numIngestersDesc
 	ch <- r.numTokensDesc
+	ch <- r.numZonesDesc
 }
 
 // Collect implements prometheus.Collector.
@@ -250,4 +371,20 @@ func (r *Ring) Collect(ch chan<- prometheus.Metric) {
 		prometheus.GaugeValue,
 		float64(len(r.ringDesc.Tokens)),
 	)
+
+	zonesByState := map[IngesterState]map[string]struct{}{}
+	for _, ingester := range r.ringDesc.Ingesters {
+		if zonesByState[ingester.State] == nil {
+			zonesByState[ingester.State] = map[string]struct{}{}
+		}
+		zonesByState[ingester.State][ingester.Zone] = struct{}{}
+	}
+	for state, zones := range zonesByState {
+		ch <- prometheus.MustNewConstMetric(
+			r.numZonesDesc,
+			prometheus.GaugeValue,
+			float64(len(zones)),
+			state.String(),
+		)
+	}
 }
@@ -0,0 +1,170 @@
+package ring
+
+import (
+	"testing"
+	"time"
+)
+
+// ringWithZones builds a Ring whose ringDesc has one token per ingester,
+// handed out in Go map iteration order (so which ingester gets which token
+// varies between runs) but with tokens sorted ascending, as candidatesFor
+// requires. ingesterZones maps ingester ID to zone; an empty zone is
+// counted by zone awareness as a zone of its own, like any other name.
+func ringWithZones(ingesterZones map[string]string, leaving map[string]bool, unhealthy map[string]bool, zoneAwarenessEnabled bool) *Ring {
+	r := &Ring{
+		heartbeatTimeout:     time.Minute,
+		zoneAwarenessEnabled: zoneAwarenessEnabled,
+		strategy:             DefaultReplicationStrategy{},
+		ringDesc: Desc{
+			Ingesters: map[string]IngesterDesc{},
+		},
+	}
+
+	now := time.Now()
+	token := uint32(0)
+	for id, zone := range ingesterZones {
+		ts := now
+		if unhealthy[id] {
+			ts = now.Add(-time.Hour)
+		}
+		state := Active
+		if leaving[id] {
+			state = Leaving
+		}
+		r.ringDesc.Ingesters[id] = IngesterDesc{Addr: id, Timestamp: ts, State: state, Zone: zone}
+		r.ringDesc.Tokens = append(r.ringDesc.Tokens, TokenDesc{Token: token, Ingester: id, State: state})
+		token += 1000
+	}
+	return r
+}
+
+func TestCandidatesFor_ZoneAwareSpreadsAcrossZones(t *testing.T) {
+	r := ringWithZones(map[string]string{
+		"a": "zone-1",
+		"b": "zone-1",
+		"c": "zone-2",
+		"d": "zone-2",
+		"e": "zone-3",
+	}, nil, nil, true)
+
+	candidates, err := r.candidatesFor(0, 3, Read)
+	if err != nil {
+		t.Fatalf("candidatesFor: %v", err)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("expected 3 candidates, got %d: %+v", len(candidates), candidates)
+	}
+	zones := map[string]struct{}{}
+	for _, c := range candidates {
+		zones[c.Zone] = struct{}{}
+	}
+	if len(zones) != 3 {
+		t.Fatalf("expected candidates to span all 3 zones, got %v", zones)
+	}
+}
+
+func TestCandidatesFor_ZoneAwareTooFewZones(t *testing.T) {
+	r := ringWithZones(map[string]string{
+		"a": "zone-1",
+		"b": "zone-1",
+		"c": "zone-2",
+	}, nil, nil, true)
+
+	_, err := r.candidatesFor(0, 3, Read)
+	if err != ErrTooFewZones {
+		t.Fatalf("expected ErrTooFewZones, got %v", err)
+	}
+}
+
+func TestCandidatesFor_ZoneAwareSkipsUnhealthyZones(t *testing.T) {
+	r := ringWithZones(map[string]string{
+		"a": "zone-1",
+		"b": "zone-2",
+		"c": "zone-3",
+	}, nil, map[string]bool{"c": true}, true)
+
+	// Only 2 zones (zone-1, zone-2) have a healthy ingester, so requesting
+	// 3 candidates should fail up front rather than being silently served
+	// from 2 zones.
+	_, err := r.candidatesFor(0, 3, Read)
+	if err != ErrTooFewZones {
+		t.Fatalf("expected ErrTooFewZones when a zone is entirely unhealthy, got %v", err)
+	}
+}
+
+func TestCandidatesFor_ZoneAwareDisabledPreservesOldBehavior(t *testing.T) {
+	r := ringWithZones(map[string]string{
+		"a": "zone-1",
+		"b": "zone-1",
+		"c": "zone-1",
+	}, nil, nil, false)
+
+	candidates, err := r.candidatesFor(0, 2, Read)
+	if err != nil {
+		t.Fatalf("candidatesFor: %v", err)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("expected 2 candidates regardless of zone, got %d", len(candidates))
+	}
+}
+
+func TestCandidatesFor_ZoneAwareIgnoresFullyDeadZone(t *testing.T) {
+	// 4 zones, one of which (zone-4) has no healthy member. RF=3 should
+	// still succeed, served entirely from the 3 healthy zones, rather than
+	// counting zone-4 as "needing a representative" and burning a replica
+	// slot on its dead ingester while a healthy zone goes unused.
+	r := ringWithZones(map[string]string{
+		"a": "zone-1",
+		"b": "zone-2",
+		"c": "zone-3",
+		"d": "zone-4",
+	}, nil, map[string]bool{"d": true}, true)
+
+	for start := uint32(0); start < 4000; start += 1000 {
+		candidates, err := r.candidatesFor(start, 3, Read)
+		if err != nil {
+			t.Fatalf("candidatesFor(%d): %v", start, err)
+		}
+		if len(candidates) != 3 {
+			t.Fatalf("candidatesFor(%d): expected 3 candidates, got %d: %+v", start, len(candidates), candidates)
+		}
+		zones := map[string]struct{}{}
+		for _, c := range candidates {
+			if c.Addr == "d" {
+				t.Fatalf("candidatesFor(%d): expected the fully-unhealthy zone-4 ingester to be excluded, got %+v", start, candidates)
+			}
+			zones[c.Zone] = struct{}{}
+		}
+		if len(zones) != 3 {
+			t.Fatalf("candidatesFor(%d): expected all 3 healthy zones represented, got %v", start, zones)
+		}
+	}
+}
+
+func TestCandidatesFor_LeavingBumpIsPerHostNotPerZone(t *testing.T) {
+	r := ringWithZones(map[string]string{
+		"a": "zone-1",
+		"b": "zone-2",
+		"c": "zone-2",
+		"d": "zone-3",
+	}, map[string]bool{"b": true}, nil, true)
+
+	// b (zone-2, Leaving) is skipped for writes; zone-2 should still be
+	// satisfiable via c, so the zone slot isn't consumed by the skip.
+	candidates, err := r.candidatesFor(0, 3, Write)
+	if err != nil {
+		t.Fatalf("candidatesFor: %v", err)
+	}
+	zones := map[string]struct{}{}
+	ids := map[string]struct{}{}
+	for _, c := range candidates {
+		zones[c.Zone] = struct{}{}
+		ids[c.Addr] = struct{}{}
+	}
+	if len(zones) != 3 {
+		t.Fatalf("expected all 3 zones represented despite b's Leaving skip, got %v", zones)
+	}
+	if _, ok := ids["b"]; ok {
+		t.Fatalf("expected Leaving ingester b to be skipped for writes, got %+v", candidates)
+	}
+}
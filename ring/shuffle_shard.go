@@ -0,0 +1,206 @@
+package ring
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shuffleShardSize records the sizes callers request from ShuffleShard, so
+// operators can see tenant shard sizing without scraping per-tenant config.
+var shuffleShardSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Name:    "cortex_ring_shuffle_shard_size",
+	Help:    "Size of the shard requested from Ring.ShuffleShard.",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+})
+
+func init() {
+	prometheus.MustRegister(shuffleShardSize)
+}
+
+// Subring is a deterministic subset of a Ring's ingesters, scoped
+// to a single tenant via ShuffleShard. It implements the same Get/BatchGet/
+// GetAll surface as Ring, filtering the parent ring's live token list down
+// to just the chosen ingesters, so ring changes (heartbeats, joins, leaves
+// of ingesters outside the shard) are picked up without re-shuffling.
+type Subring struct {
+	ring        *Ring
+	ingesterIDs map[string]struct{}
+}
+
+// ShuffleShard returns a deterministic subset of size ingesters from the
+// ring, scoped to identifier (typically a tenant ID).
+//
+// The shuffle is seeded from FNV-64a(identifier), so the same identifier
+// always yields the same shard as long as ring membership doesn't change.
+// Every draw of the seeded partial Fisher-Yates shuffle depends on the
+// number of ingesters in the pool, so adding or removing an ingester,
+// even one outside the shard, can change any or all of the shard's
+// members.
+//
+// When zone awareness is enabled, the draw happens independently per zone
+// (size/numZones per zone, remainder to the last) so shards stay
+// zone-balanced; otherwise it's drawn from all ingesters globally.
+func (r *Ring) ShuffleShard(identifier string, size int) *Subring {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	shuffleShardSize.Observe(float64(size))
+
+	rnd := rand.New(rand.NewSource(fnvSeed(identifier)))
+	ingesterIDs := map[string]struct{}{}
+
+	if !r.zoneAwarenessEnabled {
+		for _, id := range shuffleAndTake(rnd, sortedIngesterIDs(r.ringDesc.Ingesters), size) {
+			ingesterIDs[id] = struct{}{}
+		}
+		return &Subring{ring: r, ingesterIDs: ingesterIDs}
+	}
+
+	byZone := map[string][]string{}
+	for id, ing := range r.ringDesc.Ingesters {
+		byZone[ing.Zone] = append(byZone[ing.Zone], id)
+	}
+	zones := make([]string, 0, len(byZone))
+	for zone := range byZone {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	if len(zones) == 0 {
+		return &Subring{ring: r, ingesterIDs: ingesterIDs}
+	}
+
+	perZone := size / len(zones)
+	remainder := size % len(zones)
+	for i, zone := range zones {
+		take := perZone
+		if i == len(zones)-1 {
+			take += remainder
+		}
+		ids := byZone[zone]
+		sort.Strings(ids)
+		for _, id := range shuffleAndTake(rnd, ids, take) {
+			ingesterIDs[id] = struct{}{}
+		}
+	}
+
+	return &Subring{ring: r, ingesterIDs: ingesterIDs}
+}
+
+func fnvSeed(identifier string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(identifier))
+	return int64(h.Sum64())
+}
+
+func sortedIngesterIDs(ingesters map[string]IngesterDesc) []string {
+	ids := make([]string, 0, len(ingesters))
+	for id := range ingesters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// shuffleAndTake runs the first n steps of a Fisher-Yates shuffle over ids
+// (in place) and returns those first n entries, or all of them if there
+// are fewer than n. Each step draws from the ids not yet taken, so the
+// result for a given seed changes when len(ids) changes.
+func shuffleAndTake(rnd *rand.Rand, ids []string, n int) []string {
+	if n > len(ids) {
+		n = len(ids)
+	}
+	for i := 0; i < n; i++ {
+		j := i + rnd.Intn(len(ids)-i)
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids[:n]
+}
+
+// Get returns the ReplicationSet to use for op on key, scoped to this
+// shard, using the parent ring's ReplicationStrategy and replication
+// factor.
+func (s *Subring) Get(key uint32, op Operation) (ReplicationSet, error) {
+	s.ring.mtx.RLock()
+	defer s.ring.mtx.RUnlock()
+	return s.getInternal(key, op)
+}
+
+// BatchGet returns a ReplicationSet for each key, scoped to this shard.
+// The order of the result matches the order of the input.
+func (s *Subring) BatchGet(keys []uint32, op Operation) ([]ReplicationSet, error) {
+	s.ring.mtx.RLock()
+	defer s.ring.mtx.RUnlock()
+
+	result := make([]ReplicationSet, len(keys))
+	for i, key := range keys {
+		rs, err := s.getInternal(key, op)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = rs
+	}
+	return result, nil
+}
+
+func (s *Subring) getInternal(key uint32, op Operation) (ReplicationSet, error) {
+	candidates, err := s.candidatesFor(key, s.ring.replicationFactor, op)
+	if err != nil {
+		return ReplicationSet{}, err
+	}
+	return s.ring.strategy.Filter(candidates, op, s.ring.replicationFactor, s.ring.heartbeatTimeout)
+}
+
+// candidatesFor mirrors Ring.candidatesFor, scoped to this shard's own
+// token list and, when the parent ring has zone awareness enabled, its own
+// zones - not the parent ring's, since the shard may not (and for small
+// shard sizes, typically won't) span every zone the ring has.
+func (s *Subring) candidatesFor(key uint32, n int, op Operation) ([]IngesterDesc, error) {
+	tokens := s.filteredTokens()
+	if len(tokens) == 0 {
+		return nil, ErrEmptyRing
+	}
+
+	var zones map[string]struct{}
+	if s.ring.zoneAwarenessEnabled {
+		zones = s.ring.healthyZones(s.ingesterIDs)
+		if len(zones) < n {
+			return nil, ErrTooFewZones
+		}
+	}
+
+	return walkRingForCandidates(tokens, s.ring.ringDesc.Ingesters, key, n, op, zones), nil
+}
+
+// filteredTokens returns the parent ring's tokens restricted to ingesters
+// in this shard, read live so ring changes are reflected on every call.
+func (s *Subring) filteredTokens() []TokenDesc {
+	all := s.ring.ringDesc.Tokens
+	tokens := make([]TokenDesc, 0, len(all))
+	for _, t := range all {
+		if _, ok := s.ingesterIDs[t.Ingester]; ok {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// GetAll returns all available ingesters in the shard.
+func (s *Subring) GetAll() []IngesterDesc {
+	s.ring.mtx.RLock()
+	defer s.ring.mtx.RUnlock()
+
+	ingesters := make([]IngesterDesc, 0, len(s.ingesterIDs))
+	for id := range s.ingesterIDs {
+		ing, ok := s.ring.ringDesc.Ingesters[id]
+		if !ok || time.Now().Sub(ing.Timestamp) > s.ring.heartbeatTimeout {
+			continue
+		}
+		ingesters = append(ingesters, ing)
+	}
+	return ingesters
+}
@@ -0,0 +1,200 @@
+package ring
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ringWithIngesters(n int) *Ring {
+	zones := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		zones[fmt.Sprintf("ingester-%02d", i)] = ""
+	}
+	return ringWithZones(zones, nil, nil, false)
+}
+
+func shardIDs(s *Subring) map[string]struct{} {
+	return s.ingesterIDs
+}
+
+func TestShuffleShard_DeterministicForSameIdentifier(t *testing.T) {
+	r := ringWithIngesters(20)
+
+	first := shardIDs(r.ShuffleShard("tenant-a", 5))
+	second := shardIDs(r.ShuffleShard("tenant-a", 5))
+
+	if len(first) != len(second) {
+		t.Fatalf("expected repeat calls to return the same size, got %d and %d", len(first), len(second))
+	}
+	for id := range first {
+		if _, ok := second[id]; !ok {
+			t.Fatalf("expected repeat calls for the same identifier to return the same shard, %s present only in the first", id)
+		}
+	}
+}
+
+func TestShuffleShard_SizeAndMembership(t *testing.T) {
+	r := ringWithIngesters(20)
+
+	shard := r.ShuffleShard("tenant-a", 5)
+	ids := shardIDs(shard)
+	if len(ids) != 5 {
+		t.Fatalf("expected shard of size 5, got %d", len(ids))
+	}
+	for id := range ids {
+		if _, ok := r.ringDesc.Ingesters[id]; !ok {
+			t.Fatalf("shard contains %s, which isn't in the ring", id)
+		}
+	}
+}
+
+func TestShuffleShard_SizeCappedAtRingSize(t *testing.T) {
+	r := ringWithIngesters(3)
+
+	shard := r.ShuffleShard("tenant-a", 10)
+	if len(shardIDs(shard)) != 3 {
+		t.Fatalf("expected shard size to be capped at the number of ingesters (3), got %d", len(shardIDs(shard)))
+	}
+}
+
+func TestShuffleShard_TenantsGetIsolatedShards(t *testing.T) {
+	r := ringWithIngesters(30)
+
+	tenants := []string{"tenant-a", "tenant-b", "tenant-c", "tenant-d", "tenant-e"}
+	shards := make([]map[string]struct{}, len(tenants))
+	for i, tenant := range tenants {
+		shards[i] = shardIDs(r.ShuffleShard(tenant, 3))
+	}
+
+	// If ShuffleShard ignored the identifier, every tenant would land on
+	// the exact same shard. With a small shard size drawn from a much
+	// larger ring, at least one pair of tenants among five should differ.
+	allIdentical := true
+	for i := 1; i < len(shards); i++ {
+		if !sameIDSet(shards[0], shards[i]) {
+			allIdentical = false
+			break
+		}
+	}
+	if allIdentical {
+		t.Fatal("expected different tenants to be isolated onto different shards, but all tenants got the same one")
+	}
+}
+
+func TestShuffleShard_ZoneAwareSplitsEvenlyAcrossZones(t *testing.T) {
+	zones := map[string]string{}
+	for i := 0; i < 9; i++ {
+		zone := []string{"zone-1", "zone-2", "zone-3"}[i%3]
+		zones[fmt.Sprintf("ingester-%02d", i)] = zone
+	}
+	r := ringWithZones(zones, nil, nil, true)
+
+	shard := r.ShuffleShard("tenant-a", 6)
+	ids := shardIDs(shard)
+	if len(ids) != 6 {
+		t.Fatalf("expected shard of size 6, got %d", len(ids))
+	}
+
+	perZone := map[string]int{}
+	for id := range ids {
+		perZone[r.ringDesc.Ingesters[id].Zone]++
+	}
+	for _, zone := range []string{"zone-1", "zone-2", "zone-3"} {
+		if perZone[zone] != 2 {
+			t.Fatalf("expected 2 ingesters from %s, got %d (%+v)", zone, perZone[zone], perZone)
+		}
+	}
+}
+
+func TestShuffleShard_ZoneAwareEmptyRingDoesNotPanic(t *testing.T) {
+	r := ringWithZones(map[string]string{}, nil, nil, true)
+
+	shard := r.ShuffleShard("tenant-a", 5)
+	if len(shardIDs(shard)) != 0 {
+		t.Fatalf("expected an empty shard from an empty ring, got %v", shardIDs(shard))
+	}
+}
+
+func TestSubring_ZoneAwareGetSpreadsAcrossShardZones(t *testing.T) {
+	zones := map[string]string{}
+	for i := 0; i < 9; i++ {
+		zone := []string{"zone-1", "zone-2", "zone-3"}[i%3]
+		zones[fmt.Sprintf("ingester-%02d", i)] = zone
+	}
+	r := ringWithZones(zones, nil, nil, true)
+	r.replicationFactor = 3
+
+	shard := r.ShuffleShard("tenant-a", 3)
+	ids := shardIDs(shard)
+	if len(ids) != 3 {
+		t.Fatalf("expected a 3-ingester shard (one per zone), got %d: %v", len(ids), ids)
+	}
+
+	for start := uint32(0); start < 9000; start += 1000 {
+		candidates, err := shard.candidatesFor(start, r.replicationFactor, Read)
+		if err != nil {
+			t.Fatalf("candidatesFor(%d): %v", start, err)
+		}
+		if len(candidates) != 3 {
+			t.Fatalf("candidatesFor(%d): expected 3 candidates, got %d: %+v", start, len(candidates), candidates)
+		}
+		gotZones := map[string]struct{}{}
+		for _, c := range candidates {
+			gotZones[c.Zone] = struct{}{}
+		}
+		if len(gotZones) != 3 {
+			t.Fatalf("candidatesFor(%d): expected candidates to span all 3 zones the shard draws from, got %v", start, gotZones)
+		}
+	}
+}
+
+func TestShuffleShard_StableUnderChurn(t *testing.T) {
+	before := ringWithIngesters(30)
+	// Simulate an unrelated ingester joining the ring.
+	after := ringWithIngesters(31)
+
+	// The whole point of shuffle sharding over modulo sharding is that one
+	// unrelated ingester joining shouldn't reshuffle every tenant's shard
+	// from scratch. A single tenant's shard can still land on zero shared
+	// members (the draw is probabilistic), so check the property across
+	// many tenants instead: most of them should keep at least one member.
+	const numTenants = 30
+	stable := 0
+	for i := 0; i < numTenants; i++ {
+		tenant := fmt.Sprintf("tenant-%d", i)
+
+		beforeShard := shardIDs(before.ShuffleShard(tenant, 5))
+		afterShard := shardIDs(after.ShuffleShard(tenant, 5))
+		if len(beforeShard) != 5 || len(afterShard) != 5 {
+			t.Fatalf("expected shard size to stay 5 across churn, got %d -> %d", len(beforeShard), len(afterShard))
+		}
+		for id := range afterShard {
+			if _, ok := after.ringDesc.Ingesters[id]; !ok {
+				t.Fatalf("post-churn shard contains %s, which isn't in the new ring", id)
+			}
+		}
+
+		for id := range beforeShard {
+			if _, ok := afterShard[id]; ok {
+				stable++
+				break
+			}
+		}
+	}
+
+	if stable < numTenants/2 {
+		t.Fatalf("expected most tenants to retain at least one shard member after a single ingester joined, only %d/%d did", stable, numTenants)
+	}
+}
+
+func sameIDSet(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if _, ok := b[id]; !ok {
+			return false
+		}
+	}
+	return true
+}